@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// boundedRetrier wraps an exponential backoff but gives up once
+// maxRetries retries have been made (the client numbers retries from 1),
+// so a persistently unreachable cluster fails fast instead of retrying
+// forever.
+type boundedRetrier struct {
+	backoff    elastic.Backoff
+	maxRetries int
+}
+
+func (r *boundedRetrier) Retry(ctx context.Context, retry int, req *http.Request, resp *http.Response, err error) (time.Duration, bool, error) {
+	if retry > r.maxRetries {
+		return 0, false, nil
+	}
+	wait, ok := r.backoff.Next(retry)
+	if !ok {
+		return 0, false, nil
+	}
+	return wait, true, nil
+}
+
+// newElasticClient builds the Elasticsearch client used by every mode.
+// urls may list several nodes for a multi-node cluster with failover;
+// simpleClient swaps NewClient for NewSimpleClient, which skips the
+// cluster version/plugin checks NewClient does on startup.
+func newElasticClient(urls []string, headers http.Header, maxRetries int, sniff, healthcheck, simpleClient bool) (*elastic.Client, error) {
+	httpClient := &http.Client{Timeout: 60 * time.Second}
+
+	if simpleClient {
+		client, err := elastic.NewSimpleClient(
+			elastic.SetURL(urls...),
+			elastic.SetHeaders(headers),
+			elastic.SetHttpClient(httpClient),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("creating simple client: %w", err)
+		}
+		return client, nil
+	}
+
+	retrier := &boundedRetrier{
+		backoff:    elastic.NewExponentialBackoff(10*time.Millisecond, 8*time.Second),
+		maxRetries: maxRetries,
+	}
+	client, err := elastic.NewClient(
+		elastic.SetURL(urls...),
+		elastic.SetHeaders(headers),
+		elastic.SetSniff(sniff),
+		elastic.SetHealthcheck(healthcheck),
+		elastic.SetRetrier(retrier),
+		elastic.SetHttpClient(httpClient),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("creating client: %w", err)
+	}
+	return client, nil
+}
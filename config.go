@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// KafkaConfig is the shape of the -config YAML/JSON file used by
+// -mode kafka-sink and -mode kafka-source. It carries everything needed
+// to talk to a Kafka cluster plus the routing rule used to pick a
+// partition key when publishing hits.
+type KafkaConfig struct {
+	Brokers  []string `yaml:"brokers"`
+	Topic    string   `yaml:"topic"`
+	GroupID  string   `yaml:"group_id"`
+	KeyField string   `yaml:"key_field"` // dotted field path used as the partition key, e.g. "url.domain"
+}
+
+// loadKafkaConfig reads and validates a Kafka config file. It accepts
+// either YAML or JSON, since JSON is valid YAML.
+func loadKafkaConfig(path string) (*KafkaConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config %s: %w", path, err)
+	}
+
+	var cfg KafkaConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+
+	if len(cfg.Brokers) == 0 {
+		return nil, fmt.Errorf("config %s: at least one broker is required", path)
+	}
+	if cfg.Topic == "" {
+		return nil, fmt.Errorf("config %s: topic is required", path)
+	}
+	if cfg.KeyField == "" {
+		cfg.KeyField = "url.domain"
+	}
+
+	return &cfg, nil
+}
+
+// FilterConfig describes one stage of the filter chain. Type selects which
+// built-in filter it configures; the remaining fields are only consulted
+// by the types that use them.
+type FilterConfig struct {
+	Type         string   `yaml:"type"`
+	Fields       []string `yaml:"fields,omitempty"`        // field-drop
+	From         string   `yaml:"from,omitempty"`          // field-rename
+	To           string   `yaml:"to,omitempty"`            // field-rename
+	Field        string   `yaml:"field,omitempty"`         // regex-extract, timestamp-reformat, geoip
+	Pattern      string   `yaml:"pattern,omitempty"`       // regex-extract
+	InputLayout  string   `yaml:"input_layout,omitempty"`  // timestamp-reformat
+	OutputLayout string   `yaml:"output_layout,omitempty"` // timestamp-reformat
+	DBPath       string   `yaml:"db_path,omitempty"`       // geoip
+}
+
+// FilterChainConfig is the shape of the -config file used by the
+// pluggable filter pipeline: an ordered list of filters plus an optional
+// text/template used to format each surviving record into an output
+// line. Template defaults to "{{.message}}" to match the tool's
+// historical behavior of writing just the message field.
+type FilterChainConfig struct {
+	Filters  []FilterConfig `yaml:"filters,omitempty"`
+	Template string         `yaml:"template,omitempty"`
+}
+
+// loadFilterChainConfig reads the filter chain section of a -config file.
+// An empty path is not an error: it just means no filters and the
+// default template apply. A path that cannot be read is an error.
+func loadFilterChainConfig(path string) (*FilterChainConfig, error) {
+	cfg := &FilterChainConfig{Template: "{{.message}}"}
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config %s: %w", path, err)
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	if cfg.Template == "" {
+		cfg.Template = "{{.message}}"
+	}
+	return cfg, nil
+}
@@ -2,37 +2,208 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"strings"
 	"sync"
+	"text/template"
 	"time"
 
 	"github.com/olivere/elastic/v7"
 )
 
+// checkpointState is the JSON state persisted by -checkpoint. It records,
+// per hourly bucket, the highest @timestamp successfully written so far,
+// plus the current scroll id per slice and a hash of the query that
+// produced it. On restart, hits whose @timestamp is <= the bucket's
+// high-water mark are skipped instead of being re-downloaded.
+type checkpointState struct {
+	QueryHash      string               `json:"query_hash"`
+	Buckets        map[string]time.Time `json:"buckets"`
+	SliceScrollIDs map[int]string       `json:"slice_scroll_ids"`
+}
+
+// queryHash fingerprints the parameters that define the result set, so a
+// checkpoint file from a different query -- or a different slice
+// partitioning of the same query, which gives saved scroll ids and
+// high-water marks a different meaning -- is never reused by mistake. It
+// hashes daysBack itself rather than the "now - daysBack" timestamp the
+// query is actually built from, since that timestamp moves every time the
+// tool runs and would fail to match its own checkpoint on every restart.
+func queryHash(indexName, urlDomain string, daysBack, slices int) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%d|%d", indexName, urlDomain, daysBack, slices)))
+	return fmt.Sprintf("%x", sum)
+}
+
+// loadCheckpoint reads a checkpoint file if present. A missing file is not
+// an error; it just means this is a fresh run.
+func loadCheckpoint(path string) (*checkpointState, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &checkpointState{Buckets: map[string]time.Time{}, SliceScrollIDs: map[int]string{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state checkpointState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	if state.Buckets == nil {
+		state.Buckets = map[string]time.Time{}
+	}
+	if state.SliceScrollIDs == nil {
+		state.SliceScrollIDs = map[int]string{}
+	}
+	return &state, nil
+}
+
+// saveCheckpoint writes state to path atomically: it fsyncs a temp file
+// and renames it into place, so a mid-write kill never leaves a truncated
+// or corrupt checkpoint behind.
+func saveCheckpoint(path string, state *checkpointState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
 /*
-Change the values on lines 28-33 to match your environment.
-By default, this program will download logs from the last day.
+Change the apiKey, indexName, numThreads, logStoreDir, and urlDomain
+values below to match your environment, and point -es-urls at your
+cluster. By default, this program will download logs from the last day.
 You can change the number of days back by using the -days flag.
+
+-mode selects how the tool moves data: "es-dump" (default) scrolls
+Elasticsearch straight to hourly files, "kafka-sink" scrolls Elasticsearch
+and publishes each hit to a Kafka topic, and "kafka-source" consumes a
+Kafka topic and writes hourly files from it. The kafka-* modes read their
+broker/topic/group settings from the file passed via -config.
+
+-es-urls accepts a comma-separated list of node URLs for multi-node
+clusters with failover; -max-retries, -sniff, -healthcheck, and
+-simple-client control how the client talks to that cluster.
+
+Hourly files are written as gzip-compressed "YYYY-MM-DD-HH.NNN.txt.gz"
+segments, one open writer per bucket. -max-file-size and -max-file-lines
+roll a bucket over to the next segment before a line would take it past
+either limit.
+-format selects what each line looks like: "raw" (default) writes the
+message field via an optional -config template, "json" writes the full
+filtered _source pretty-printed, and "ndjson" writes it as one compact
+JSON object per line. SIGINT flushes and closes all open writers before
+exiting.
 */
 
+// hourlyBucketName formats a timestamp into the hourly bucket key it
+// belongs in, e.g. "2006-01-02-15". The writer manager turns this into
+// an actual file name (with sequence number and .txt.gz extension).
+func hourlyBucketName(timestamp time.Time) string {
+	return timestamp.Format("2006-01-02-15")
+}
+
+// parseTimestamp parses an Elasticsearch "@timestamp" string.
+func parseTimestamp(timestampStr string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, timestampStr)
+}
+
+// formatRecord renders a filtered record as an output line according to
+// format, which is one of "raw" (run it through tmpl, the historical
+// behavior), "json" (pretty-printed _source), or "ndjson" (one compact
+// JSON object per line).
+func formatRecord(format string, record map[string]interface{}, tmpl *template.Template) (string, error) {
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(record, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	case "ndjson":
+		data, err := json.Marshal(record)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	case "raw", "":
+		var buf strings.Builder
+		if err := tmpl.Execute(&buf, record); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	default:
+		return "", fmt.Errorf("unknown -format %q", format)
+	}
+}
+
 func main() {
 	// Command-line flags
 	var daysBack int
+	var slices int
+	var checkpointPath string
+	var mode string
+	var configPath string
+	var esURLs string
+	var maxRetries int
+	var sniff bool
+	var healthcheck bool
+	var simpleClient bool
+	var maxFileSize int64
+	var maxFileLines int
+	var format string
 	flag.IntVar(&daysBack, "days", 1, "Number of days back to download logs")
+	flag.IntVar(&slices, "slices", 0, "Number of sliced scroll partitions to run in parallel (defaults to numThreads)")
+	flag.StringVar(&checkpointPath, "checkpoint", "", "Path to a checkpoint file to resume an interrupted download from")
+	flag.StringVar(&mode, "mode", "es-dump", "Run mode: es-dump (default), kafka-sink, or kafka-source")
+	flag.StringVar(&configPath, "config", "", "Path to a YAML/JSON config file (required for kafka-sink and kafka-source)")
+	flag.StringVar(&esURLs, "es-urls", "https://ES_URL.DOMAINNAME.COM:9200", "Comma-separated list of Elasticsearch node URLs")
+	flag.IntVar(&maxRetries, "max-retries", 5, "Maximum number of retries per Elasticsearch request")
+	flag.BoolVar(&sniff, "sniff", false, "Enable client-side sniffing to discover all cluster nodes")
+	flag.BoolVar(&healthcheck, "healthcheck", false, "Enable periodic node healthchecks")
+	flag.BoolVar(&simpleClient, "simple-client", false, "Use elastic.NewSimpleClient instead of NewClient, skipping startup version/plugin checks")
+	flag.Int64Var(&maxFileSize, "max-file-size", 0, "Roll over to a new hourly file segment after this many bytes (0 disables)")
+	flag.IntVar(&maxFileLines, "max-file-lines", 0, "Roll over to a new hourly file segment after this many lines (0 disables)")
+	flag.StringVar(&format, "format", "raw", "Output line format: raw (message field via template), json, or ndjson (full _source)")
 	flag.Parse()
 
-	esURL := "https://ES_URL.DOMAINNAME.COM:9200" // Change to your Elasticsearch URL
-	apiKey := "YOUR_ES_API_KEY"                   // Change to your API key
-	indexName := "YOUR_ES_INDEX_NAME"             // Change to your index name, can include an *
-	numThreads := 4                               // Number of concurrent threads
-	logStoreDir := "logdir"                       // Directory to store logs
-	urlDomain := "URL_FROM_ES_url.domain_FIELD"   // URL domain to filter
+	apiKey := "YOUR_ES_API_KEY"                 // Change to your API key
+	indexName := "YOUR_ES_INDEX_NAME"           // Change to your index name, can include an *
+	numThreads := 4                             // Number of concurrent threads
+	logStoreDir := "logdir"                     // Directory to store logs
+	urlDomain := "URL_FROM_ES_url.domain_FIELD" // URL domain to filter
+
+	if slices <= 0 {
+		slices = numThreads
+	}
+
+	urls := strings.Split(esURLs, ",")
+	for i := range urls {
+		urls[i] = strings.TrimSpace(urls[i])
+	}
 
 	// Ensure directory exists or create it
 	err := os.MkdirAll(logStoreDir, 0755)
@@ -40,20 +211,59 @@ func main() {
 		log.Fatalf("Error creating directory: %s", err)
 	}
 
+	// A signal-aware context so SIGINT stops scrolling/consuming cleanly
+	// instead of leaving gzip writers unflushed.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	writers := newHourlyWriterManager(logStoreDir, maxFileSize, maxFileLines)
+
+	// Load the filter chain and output template up front, since both the
+	// es-dump path and kafka-source need to run every record through them
+	// before it's written to its hourly file. With no -config, this is an
+	// empty chain and the historical "{{.message}}" template, so behavior
+	// is unchanged from before the pipeline existed.
+	filterChainConfig, err := loadFilterChainConfig(configPath)
+	if err != nil {
+		log.Fatalf("Error loading filter chain config: %s", err)
+	}
+	filterChain, err := buildFilterChain(filterChainConfig.Filters)
+	if err != nil {
+		log.Fatalf("Error building filter chain: %s", err)
+	}
+	outputTemplate, err := template.New("record").Parse(filterChainConfig.Template)
+	if err != nil {
+		log.Fatalf("Error parsing output template: %s", err)
+	}
+
+	// kafka-source never touches Elasticsearch: it just consumes a topic
+	// that some other process (or a kafka-sink run) already populated and
+	// writes hourly files from it.
+	if mode == "kafka-source" {
+		if configPath == "" {
+			log.Fatalf("-config is required for -mode kafka-source")
+		}
+		cfg, err := loadKafkaConfig(configPath)
+		if err != nil {
+			log.Fatalf("Error loading Kafka config: %s", err)
+		}
+		runErr := runKafkaSource(ctx, cfg, writers, filterChain, outputTemplate, format)
+		if err := writers.CloseAll(); err != nil {
+			log.Printf("Error closing hourly writers: %s", err)
+		}
+		if runErr != nil && ctx.Err() == nil {
+			log.Fatalf("Error running Kafka source: %s", runErr)
+		}
+		return
+	}
+
 	// Create an HTTP header with the API key
 	headers := http.Header{}
 	headers.Set("Authorization", "ApiKey "+apiKey)
 
-	// Elasticsearch client with custom HTTP headers and increased timeout
-	client, err := elastic.NewClient(
-		elastic.SetURL(esURL),
-		elastic.SetHeaders(headers),
-		elastic.SetSniff(false),
-		elastic.SetHealthcheck(false),
-		elastic.SetHttpClient(&http.Client{
-			Timeout: 60 * time.Second,
-		}),
-	)
+	// Elasticsearch client with custom HTTP headers, multi-node failover,
+	// and bounded retries
+	client, err := newElasticClient(urls, headers, maxRetries, sniff, healthcheck, simpleClient)
 	if err != nil {
 		log.Fatalf("Error creating the client: %s", err)
 	}
@@ -64,9 +274,26 @@ func main() {
 		Filter(elastic.NewRangeQuery("@timestamp").Gte(daysAgo.Format(time.RFC3339))).
 		Filter(elastic.NewTermQuery("url.domain", urlDomain))
 
+	// kafka-sink scrolls the same query but publishes hits to Kafka
+	// instead of writing hourly files.
+	if mode == "kafka-sink" {
+		if configPath == "" {
+			log.Fatalf("-config is required for -mode kafka-sink")
+		}
+		cfg, err := loadKafkaConfig(configPath)
+		if err != nil {
+			log.Fatalf("Error loading Kafka config: %s", err)
+		}
+		if err := runKafkaSink(ctx, client, query, indexName, slices, cfg, filterChain); err != nil {
+			log.Fatalf("Error running Kafka sink: %s", err)
+		}
+		log.Printf("Published hits from index %s to Kafka topic %s", indexName, cfg.Topic)
+		return
+	}
+
 	// Estimate total hits
 	countQuery := client.Count(indexName).Query(query)
-	countResult, err := countQuery.Do(context.Background())
+	countResult, err := countQuery.Do(ctx)
 	if err != nil {
 		log.Fatalf("Error estimating total hits: %s", err)
 	}
@@ -74,82 +301,152 @@ func main() {
 	// Total number of hits
 	totalHits := countResult
 
+	// Load (or initialize) checkpoint state. A hash mismatch means the
+	// query changed since the checkpoint was written, so the old
+	// high-water marks no longer apply and the run starts fresh.
+	var checkpoint *checkpointState
+	var checkpointMu sync.Mutex
+	hitsSinceCheckpoint := 0
+	const checkpointEvery = 500
+	resumeMarks := map[string]time.Time{}
+	if checkpointPath != "" {
+		checkpoint, err = loadCheckpoint(checkpointPath)
+		if err != nil {
+			log.Fatalf("Error loading checkpoint %s: %s", checkpointPath, err)
+		}
+		hash := queryHash(indexName, urlDomain, daysBack, slices)
+		if checkpoint.QueryHash != hash {
+			checkpoint = &checkpointState{QueryHash: hash, Buckets: map[string]time.Time{}, SliceScrollIDs: map[int]string{}}
+		}
+		// Only the marks loaded at startup decide which hits to skip; marks
+		// advanced during this run never drop this run's own hits.
+		for bucket, mark := range checkpoint.Buckets {
+			resumeMarks[bucket] = mark
+		}
+	}
+
 	// Initialize variables for pagination and concurrency
-	scroll := client.Scroll(indexName).Query(query).Size(1000) // Adjust size as needed
-	ctx := context.Background()
 	var wg sync.WaitGroup
-	hitCh := make(chan *elastic.SearchHit)
-	done := make(chan struct{})
 	var processedHits int64 // Track processed hits
+	var processedMu sync.Mutex
 
-	// Function to process hits
-	processHits := func() {
-		defer wg.Done()
-		localProcessedHits := 0 // Track processed hits per goroutine
-		for hit := range hitCh {
-			localProcessedHits++
-			processedHits++ // Increment global processed hits count
-
-			// Unmarshal hit.Source into a map[string]interface{}
-			var source map[string]interface{}
-			if err := json.Unmarshal(hit.Source, &source); err != nil {
-				log.Printf("Error unmarshaling document ID %s: %s", hit.Id, err)
-				continue
-			}
+	// writeHit decodes a single hit, skips it if its @timestamp is <= the
+	// bucket's high-water mark in the checkpoint loaded at startup, and
+	// otherwise appends it to its hourly file and advances the bucket's
+	// high-water mark.
+	writeHit := func(hit *elastic.SearchHit) {
+		// Unmarshal hit.Source into a map[string]interface{}
+		var source map[string]interface{}
+		if err := json.Unmarshal(hit.Source, &source); err != nil {
+			log.Printf("Error unmarshaling document ID %s: %s", hit.Id, err)
+			return
+		}
 
-			// Extract timestamp and format it by hour
-			timestampStr, ok := source["@timestamp"].(string)
-			if !ok {
-				log.Printf("Document ID %s: '@timestamp' field is not a string", hit.Id)
-				continue
-			}
-			timestamp, err := time.Parse(time.RFC3339Nano, timestampStr)
-			if err != nil {
-				log.Printf("Error parsing timestamp for document ID %s: %s", hit.Id, err)
-				continue
-			}
-			hourlyFileName := timestamp.Format("2006-01-02-15") + ".txt" // Format: YYYY-MM-DD-HH
-			filePath := filepath.Join(logStoreDir, hourlyFileName)
+		// Extract timestamp and format it by hour
+		timestampStr, ok := source["@timestamp"].(string)
+		if !ok {
+			log.Printf("Document ID %s: '@timestamp' field is not a string", hit.Id)
+			return
+		}
+		timestamp, err := parseTimestamp(timestampStr)
+		if err != nil {
+			log.Printf("Error parsing timestamp for document ID %s: %s", hit.Id, err)
+			return
+		}
+		hourlyFileName := hourlyBucketName(timestamp)
 
-			// Open or create the hourly file
-			file, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-			if err != nil {
-				log.Printf("Error opening file %s: %s", filePath, err)
-				continue
+		if checkpoint != nil {
+			highWaterMark, seen := resumeMarks[hourlyFileName]
+			if seen && !timestamp.After(highWaterMark) {
+				return
 			}
+		}
 
-			// Write "message" (or any other desired field) to file
-			message, ok := source["message"].(string)
-			if !ok {
-				log.Printf("Document ID %s: 'message' field is not a string", hit.Id)
-				continue
+		// Run the record through the configured filter chain, then format
+		// it into an output line via -format.
+		filtered, keep, err := applyFilterChain(filterChain, source)
+		if err != nil {
+			log.Printf("Error filtering document ID %s: %s", hit.Id, err)
+			return
+		}
+		if !keep {
+			return
+		}
+
+		line, err := formatRecord(format, filtered, outputTemplate)
+		if err != nil {
+			log.Printf("Error formatting document ID %s: %s", hit.Id, err)
+			return
+		}
+		if err := writers.WriteLine(hourlyFileName, line); err != nil {
+			log.Printf("Error writing to file for document ID %s: %s", hit.Id, err)
+		}
+
+		if checkpoint != nil {
+			checkpointMu.Lock()
+			if highWaterMark, seen := checkpoint.Buckets[hourlyFileName]; !seen || timestamp.After(highWaterMark) {
+				checkpoint.Buckets[hourlyFileName] = timestamp
 			}
-			if _, err := file.WriteString(fmt.Sprintf("%s\n", message)); err != nil {
-				log.Printf("Error writing to file %s: %s", filePath, err)
+			hitsSinceCheckpoint++
+			dueForSave := hitsSinceCheckpoint >= checkpointEvery
+			if dueForSave {
+				hitsSinceCheckpoint = 0
 			}
+			checkpointMu.Unlock()
 
-			// Close file immediately after writing
-			if err := file.Close(); err != nil {
-				log.Printf("Error closing file %s: %s", filePath, err)
+			if dueForSave {
+				if err := saveCheckpoint(checkpointPath, checkpoint); err != nil {
+					log.Printf("Error saving checkpoint %s: %s", checkpointPath, err)
+				}
 			}
 		}
-		log.Printf("Processed %d hits", localProcessedHits)
 	}
 
-	// Start workers
-	for i := 0; i < numThreads; i++ {
-		wg.Add(1)
-		go processHits()
-	}
+	// sliceWorker scrolls a single slice of the result set to exhaustion,
+	// so each worker pulls an independent partition instead of funneling
+	// every hit through a single scroller and channel.
+	sliceWorker := func(sliceID int) {
+		defer wg.Done()
 
-	// Process hits and send to channel
-	go func() {
-		defer close(hitCh)
+		sliceQuery := elastic.NewSliceQuery().Id(sliceID).Max(slices)
+		scroll := client.Scroll(indexName).Query(query).Sort("@timestamp", true).Sort("_doc", true).Slice(sliceQuery).Size(1000)
+
+		// Resume the slice's saved scroll id, if the checkpoint has one.
+		// ES scroll contexts have their own keep-alive and commonly expire
+		// between runs, so a rejected scroll id just falls back to a fresh
+		// scroll from the top of the slice rather than failing the worker.
+		if checkpoint != nil {
+			checkpointMu.Lock()
+			savedScrollID := checkpoint.SliceScrollIDs[sliceID]
+			checkpointMu.Unlock()
+			if savedScrollID != "" {
+				scroll = scroll.ScrollId(savedScrollID)
+			}
+		}
 
+		localProcessedHits := 0
 		for {
 			results, err := scroll.Do(ctx)
+			if err == io.EOF {
+				break
+			}
 			if err != nil {
-				log.Printf("Error scrolling: %s", err)
+				if ctx.Err() != nil {
+					log.Printf("Slice %d stopping: %s", sliceID, ctx.Err())
+					break
+				}
+				if checkpoint != nil {
+					checkpointMu.Lock()
+					hadSavedScrollID := checkpoint.SliceScrollIDs[sliceID] != ""
+					checkpoint.SliceScrollIDs[sliceID] = ""
+					checkpointMu.Unlock()
+					if hadSavedScrollID {
+						log.Printf("Slice %d: saved scroll id rejected (%s), starting a fresh scroll", sliceID, err)
+						scroll = client.Scroll(indexName).Query(query).Sort("@timestamp", true).Sort("_doc", true).Slice(sliceQuery).Size(1000)
+						continue
+					}
+				}
+				log.Printf("Error scrolling slice %d: %s", sliceID, err)
 				break
 			}
 
@@ -157,19 +454,33 @@ func main() {
 				break
 			}
 
+			if checkpoint != nil {
+				checkpointMu.Lock()
+				checkpoint.SliceScrollIDs[sliceID] = results.ScrollId
+				checkpointMu.Unlock()
+			}
+
 			for _, hit := range results.Hits.Hits {
-				hitCh <- hit
+				writeHit(hit)
+				localProcessedHits++
+				processedMu.Lock()
+				processedHits++
+				processedMu.Unlock()
 			}
 		}
+		log.Printf("Slice %d processed %d hits", sliceID, localProcessedHits)
+	}
 
-		// Signal done
-		close(done)
-	}()
+	// Start one worker per slice, each scrolling its own partition in parallel
+	done := make(chan struct{})
+	for i := 0; i < slices; i++ {
+		wg.Add(1)
+		go sliceWorker(i)
+	}
 
-	// Wait for processing to complete
 	go func() {
 		wg.Wait()
-		close(hitCh)
+		close(done)
 	}()
 
 	// Print progress and completion message
@@ -177,10 +488,21 @@ func main() {
 	for {
 		select {
 		case <-done:
+			if checkpoint != nil {
+				if err := saveCheckpoint(checkpointPath, checkpoint); err != nil {
+					log.Printf("Error saving checkpoint %s: %s", checkpointPath, err)
+				}
+			}
+			if err := writers.CloseAll(); err != nil {
+				log.Printf("Error closing hourly writers: %s", err)
+			}
 			log.Printf("Data has been written to hourly files in subdirectory %s", logStoreDir)
 			return
 		case <-time.After(10 * time.Second):
-			log.Printf("Processed %d out of %d hits in %s", processedHits, totalHits, time.Since(startTime))
+			processedMu.Lock()
+			processed := processedHits
+			processedMu.Unlock()
+			log.Printf("Processed %d out of %d hits in %s", processed, totalHits, time.Since(startTime))
 		}
 	}
 }
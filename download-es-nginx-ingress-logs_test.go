@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestQueryHashStableAcrossCalls(t *testing.T) {
+	a := queryHash("my-index", "example.com", 7, 4)
+	b := queryHash("my-index", "example.com", 7, 4)
+	if a != b {
+		t.Fatalf("queryHash should be deterministic for identical inputs, got %q and %q", a, b)
+	}
+}
+
+func TestQueryHashDiffersOnDaysBack(t *testing.T) {
+	a := queryHash("my-index", "example.com", 1, 4)
+	b := queryHash("my-index", "example.com", 7, 4)
+	if a == b {
+		t.Fatalf("queryHash should differ when daysBack changes")
+	}
+}
+
+func TestQueryHashDiffersOnSlices(t *testing.T) {
+	a := queryHash("my-index", "example.com", 7, 4)
+	b := queryHash("my-index", "example.com", 7, 8)
+	if a == b {
+		t.Fatalf("queryHash should differ when slices changes, since saved scroll ids/high-water marks are keyed by slice partitioning")
+	}
+}
+
+func TestSaveAndLoadCheckpointRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "checkpoint.json")
+
+	ts := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+	want := &checkpointState{
+		QueryHash:      "abc123",
+		Buckets:        map[string]time.Time{"2024-01-02-03": ts},
+		SliceScrollIDs: map[int]string{0: "scroll-id-0"},
+	}
+	if err := saveCheckpoint(path, want); err != nil {
+		t.Fatalf("saveCheckpoint: %s", err)
+	}
+
+	got, err := loadCheckpoint(path)
+	if err != nil {
+		t.Fatalf("loadCheckpoint: %s", err)
+	}
+	if got.QueryHash != want.QueryHash {
+		t.Errorf("QueryHash = %q, want %q", got.QueryHash, want.QueryHash)
+	}
+	if !got.Buckets["2024-01-02-03"].Equal(ts) {
+		t.Errorf("Buckets[...] = %v, want %v", got.Buckets["2024-01-02-03"], ts)
+	}
+	if got.SliceScrollIDs[0] != "scroll-id-0" {
+		t.Errorf("SliceScrollIDs[0] = %q, want %q", got.SliceScrollIDs[0], "scroll-id-0")
+	}
+
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("expected temp file to be renamed away, stat err = %v", err)
+	}
+}
+
+func TestLoadCheckpointMissingFileIsFresh(t *testing.T) {
+	dir := t.TempDir()
+	state, err := loadCheckpoint(filepath.Join(dir, "does-not-exist.json"))
+	if err != nil {
+		t.Fatalf("loadCheckpoint: %s", err)
+	}
+	if len(state.Buckets) != 0 || len(state.SliceScrollIDs) != 0 {
+		t.Errorf("expected a fresh empty checkpoint, got %+v", state)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// Filter transforms a decoded hit record before it is written out. It may
+// drop the record entirely (the bool return is false) or fail outright,
+// mirroring the filter chain pattern used by go-stash.
+type Filter interface {
+	Apply(record map[string]interface{}) (map[string]interface{}, bool, error)
+}
+
+// buildFilter constructs the Filter described by cfg.
+func buildFilter(cfg FilterConfig) (Filter, error) {
+	switch cfg.Type {
+	case "field-drop":
+		return &FieldDropFilter{Fields: cfg.Fields}, nil
+	case "field-rename":
+		return &FieldRenameFilter{From: cfg.From, To: cfg.To}, nil
+	case "regex-extract":
+		re, err := regexp.Compile(cfg.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("compiling regex-extract pattern %q: %w", cfg.Pattern, err)
+		}
+		return &RegexExtractFilter{Field: cfg.Field, Pattern: re}, nil
+	case "timestamp-reformat":
+		return &TimestampReformatFilter{Field: cfg.Field, InputLayout: cfg.InputLayout, OutputLayout: cfg.OutputLayout}, nil
+	case "geoip":
+		return newGeoIPFilter(cfg.Field, cfg.DBPath)
+	default:
+		return nil, fmt.Errorf("unknown filter type %q", cfg.Type)
+	}
+}
+
+// buildFilterChain constructs an ordered chain of filters from config.
+func buildFilterChain(cfgs []FilterConfig) ([]Filter, error) {
+	chain := make([]Filter, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		filter, err := buildFilter(cfg)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, filter)
+	}
+	return chain, nil
+}
+
+// applyFilterChain runs record through chain in order, short-circuiting
+// as soon as a filter drops the record or returns an error.
+func applyFilterChain(chain []Filter, record map[string]interface{}) (map[string]interface{}, bool, error) {
+	var err error
+	keep := true
+	for _, filter := range chain {
+		record, keep, err = filter.Apply(record)
+		if err != nil {
+			return nil, false, err
+		}
+		if !keep {
+			return nil, false, nil
+		}
+	}
+	return record, true, nil
+}
+
+// FieldDropFilter removes the configured top-level fields from the record.
+type FieldDropFilter struct {
+	Fields []string
+}
+
+func (f *FieldDropFilter) Apply(record map[string]interface{}) (map[string]interface{}, bool, error) {
+	for _, field := range f.Fields {
+		delete(record, field)
+	}
+	return record, true, nil
+}
+
+// FieldRenameFilter moves a top-level field to a new key, if present.
+type FieldRenameFilter struct {
+	From string
+	To   string
+}
+
+func (f *FieldRenameFilter) Apply(record map[string]interface{}) (map[string]interface{}, bool, error) {
+	if value, ok := record[f.From]; ok {
+		record[f.To] = value
+		delete(record, f.From)
+	}
+	return record, true, nil
+}
+
+// RegexExtractFilter runs Pattern against Field's string value and copies
+// each named capture group into the record under its own key.
+type RegexExtractFilter struct {
+	Field   string
+	Pattern *regexp.Regexp
+}
+
+func (f *RegexExtractFilter) Apply(record map[string]interface{}) (map[string]interface{}, bool, error) {
+	value, ok := record[f.Field].(string)
+	if !ok {
+		return record, true, nil
+	}
+	match := f.Pattern.FindStringSubmatch(value)
+	if match == nil {
+		return record, true, nil
+	}
+	for i, name := range f.Pattern.SubexpNames() {
+		if i == 0 || name == "" {
+			continue
+		}
+		record[name] = match[i]
+	}
+	return record, true, nil
+}
+
+// TimestampReformatFilter reparses Field with InputLayout (default
+// time.RFC3339Nano) and rewrites it using OutputLayout (default
+// time.RFC3339).
+type TimestampReformatFilter struct {
+	Field        string
+	InputLayout  string
+	OutputLayout string
+}
+
+func (f *TimestampReformatFilter) Apply(record map[string]interface{}) (map[string]interface{}, bool, error) {
+	raw, ok := record[f.Field].(string)
+	if !ok {
+		return record, true, nil
+	}
+
+	inputLayout := f.InputLayout
+	if inputLayout == "" {
+		inputLayout = time.RFC3339Nano
+	}
+	parsed, err := time.Parse(inputLayout, raw)
+	if err != nil {
+		return nil, false, fmt.Errorf("reformatting %s: %w", f.Field, err)
+	}
+
+	outputLayout := f.OutputLayout
+	if outputLayout == "" {
+		outputLayout = time.RFC3339
+	}
+	record[f.Field] = parsed.Format(outputLayout)
+	return record, true, nil
+}
+
+// GeoIPFilter enriches a record with city-level GeoIP data looked up from
+// Field (a dotted path, e.g. "source.ip"), stored under the "geoip" key.
+type GeoIPFilter struct {
+	Field string
+	db    *geoip2.Reader
+}
+
+func newGeoIPFilter(field, dbPath string) (*GeoIPFilter, error) {
+	if field == "" {
+		field = "source.ip"
+	}
+	db, err := geoip2.Open(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("opening GeoIP database %s: %w", dbPath, err)
+	}
+	return &GeoIPFilter{Field: field, db: db}, nil
+}
+
+func (f *GeoIPFilter) Apply(record map[string]interface{}) (map[string]interface{}, bool, error) {
+	ipStr, ok := fieldByPath(record, f.Field)
+	if !ok {
+		return record, true, nil
+	}
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return record, true, nil
+	}
+
+	city, err := f.db.City(ip)
+	if err != nil {
+		return record, true, nil
+	}
+	record["geoip"] = map[string]interface{}{
+		"country_name": city.Country.Names["en"],
+		"city_name":    city.City.Names["en"],
+		"latitude":     city.Location.Latitude,
+		"longitude":    city.Location.Longitude,
+	}
+	return record, true, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+type stubFilter struct {
+	record map[string]interface{}
+	keep   bool
+	err    error
+}
+
+func (f *stubFilter) Apply(record map[string]interface{}) (map[string]interface{}, bool, error) {
+	return f.record, f.keep, f.err
+}
+
+func TestApplyFilterChainDropsShortCircuit(t *testing.T) {
+	called := false
+	chain := []Filter{
+		&stubFilter{record: map[string]interface{}{"a": 1}, keep: false},
+		&stubFilter{record: nil, keep: true, err: nil},
+	}
+	// Wrap the second filter so we can tell if it ran.
+	chain[1] = &recordingFilter{inner: chain[1], called: &called}
+
+	_, keep, err := applyFilterChain(chain, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if keep {
+		t.Fatalf("expected chain to drop the record")
+	}
+	if called {
+		t.Fatalf("expected later filters to be skipped once a filter drops the record")
+	}
+}
+
+func TestApplyFilterChainErrorShortCircuits(t *testing.T) {
+	called := false
+	wantErr := errors.New("boom")
+	chain := []Filter{
+		&stubFilter{record: nil, keep: false, err: wantErr},
+		&recordingFilter{inner: &stubFilter{record: map[string]interface{}{}, keep: true}, called: &called},
+	}
+
+	_, keep, err := applyFilterChain(chain, map[string]interface{}{})
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("expected %v, got %v", wantErr, err)
+	}
+	if keep {
+		t.Fatalf("expected keep=false on error")
+	}
+	if called {
+		t.Fatalf("expected later filters to be skipped once a filter errors")
+	}
+}
+
+func TestApplyFilterChainAppliesEveryFilter(t *testing.T) {
+	chain := []Filter{
+		&FieldRenameFilter{From: "old", To: "new"},
+		&FieldDropFilter{Fields: []string{"drop-me"}},
+	}
+
+	record := map[string]interface{}{"old": "value", "drop-me": "gone"}
+	result, keep, err := applyFilterChain(chain, record)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !keep {
+		t.Fatalf("expected record to survive the chain")
+	}
+	if _, ok := result["drop-me"]; ok {
+		t.Errorf("expected drop-me to be removed")
+	}
+	if result["new"] != "value" {
+		t.Errorf("expected new=value, got %v", result["new"])
+	}
+}
+
+// recordingFilter wraps another Filter and records whether Apply was called.
+type recordingFilter struct {
+	inner  Filter
+	called *bool
+}
+
+func (f *recordingFilter) Apply(record map[string]interface{}) (map[string]interface{}, bool, error) {
+	*f.called = true
+	return f.inner.Apply(record)
+}
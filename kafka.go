@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/IBM/sarama"
+	"github.com/olivere/elastic/v7"
+)
+
+// fieldByPath looks up a dotted field path (e.g. "url.domain") in a
+// decoded hit source, the same way Elasticsearch addresses nested fields.
+func fieldByPath(source map[string]interface{}, path string) (string, bool) {
+	current := interface{}(source)
+	for _, part := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		current, ok = m[part]
+		if !ok {
+			return "", false
+		}
+	}
+	value, ok := current.(string)
+	return value, ok
+}
+
+// runKafkaSink scrolls the result set the same way the default ES-dump
+// mode does -- one sliced-scroll worker per slice -- but publishes each
+// hit's _source, run through filterChain, to a Kafka topic instead of
+// writing an hourly file. The partition key is the configured field,
+// falling back to a random partition (sarama's default hash partitioner
+// with no key) when a hit doesn't have it.
+func runKafkaSink(ctx context.Context, client *elastic.Client, query elastic.Query, indexName string, slices int, cfg *KafkaConfig, filterChain []Filter) error {
+	producerConfig := sarama.NewConfig()
+	producerConfig.Producer.Return.Successes = true
+	producerConfig.Producer.RequiredAcks = sarama.WaitForAll
+
+	producer, err := sarama.NewSyncProducer(cfg.Brokers, producerConfig)
+	if err != nil {
+		return fmt.Errorf("creating Kafka producer: %w", err)
+	}
+
+	sliceWorker := func(sliceID int) error {
+		sliceQuery := elastic.NewSliceQuery().Id(sliceID).Max(slices)
+		scroll := client.Scroll(indexName).Query(query).Slice(sliceQuery).Size(1000)
+
+		localPublished := 0
+		for {
+			results, err := scroll.Do(ctx)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("scrolling slice %d: %w", sliceID, err)
+			}
+			if len(results.Hits.Hits) == 0 {
+				break
+			}
+
+			for _, hit := range results.Hits.Hits {
+				var source map[string]interface{}
+				if err := json.Unmarshal(hit.Source, &source); err != nil {
+					log.Printf("Error unmarshaling document ID %s: %s", hit.Id, err)
+					continue
+				}
+
+				filtered, keep, err := applyFilterChain(filterChain, source)
+				if err != nil {
+					log.Printf("Error filtering document ID %s: %s", hit.Id, err)
+					continue
+				}
+				if !keep {
+					continue
+				}
+
+				value, err := json.Marshal(filtered)
+				if err != nil {
+					log.Printf("Error marshaling document ID %s: %s", hit.Id, err)
+					continue
+				}
+
+				msg := &sarama.ProducerMessage{
+					Topic: cfg.Topic,
+					Value: sarama.ByteEncoder(value),
+				}
+				if key, ok := fieldByPath(filtered, cfg.KeyField); ok {
+					msg.Key = sarama.StringEncoder(key)
+				}
+
+				if _, _, err := producer.SendMessage(msg); err != nil {
+					log.Printf("Error publishing document ID %s to topic %s: %s", hit.Id, cfg.Topic, err)
+					continue
+				}
+				localPublished++
+			}
+		}
+		log.Printf("Slice %d published %d hits to topic %s", sliceID, localPublished, cfg.Topic)
+		return nil
+	}
+
+	// Wait for every slice worker to finish -- including the ones that
+	// didn't error -- before closing the producer. Closing it as soon as
+	// the first error arrives would race the other still-running workers'
+	// in-flight SendMessage calls against the producer's shutdown.
+	var wg sync.WaitGroup
+	errCh := make(chan error, slices)
+	for i := 0; i < slices; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errCh <- sliceWorker(i)
+		}()
+	}
+	wg.Wait()
+	close(errCh)
+
+	if err := producer.Close(); err != nil {
+		log.Printf("Error closing Kafka producer: %s", err)
+	}
+
+	var firstErr error
+	for err := range errCh {
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// kafkaConsumerHandler writes every consumed hit to its hourly file,
+// reusing the same bucketing, filter chain, and writer-manager logic as
+// the ES-dump mode.
+type kafkaConsumerHandler struct {
+	writers        *hourlyWriterManager
+	filterChain    []Filter
+	outputTemplate *template.Template
+	format         string
+}
+
+func (h *kafkaConsumerHandler) Setup(sarama.ConsumerGroupSession) error   { return nil }
+func (h *kafkaConsumerHandler) Cleanup(sarama.ConsumerGroupSession) error { return nil }
+
+func (h *kafkaConsumerHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for msg := range claim.Messages() {
+		var source map[string]interface{}
+		if err := json.Unmarshal(msg.Value, &source); err != nil {
+			log.Printf("Error unmarshaling Kafka message at offset %d: %s", msg.Offset, err)
+			session.MarkMessage(msg, "")
+			continue
+		}
+
+		timestampStr, ok := source["@timestamp"].(string)
+		if !ok {
+			log.Printf("Kafka message at offset %d: '@timestamp' field is not a string", msg.Offset)
+			session.MarkMessage(msg, "")
+			continue
+		}
+		timestamp, err := parseTimestamp(timestampStr)
+		if err != nil {
+			log.Printf("Error parsing timestamp for Kafka message at offset %d: %s", msg.Offset, err)
+			session.MarkMessage(msg, "")
+			continue
+		}
+
+		filtered, keep, err := applyFilterChain(h.filterChain, source)
+		if err != nil {
+			log.Printf("Error filtering Kafka message at offset %d: %s", msg.Offset, err)
+			session.MarkMessage(msg, "")
+			continue
+		}
+		if !keep {
+			session.MarkMessage(msg, "")
+			continue
+		}
+
+		line, err := formatRecord(h.format, filtered, h.outputTemplate)
+		if err != nil {
+			log.Printf("Error formatting Kafka message at offset %d: %s", msg.Offset, err)
+			session.MarkMessage(msg, "")
+			continue
+		}
+
+		if err := h.writers.WriteLine(hourlyBucketName(timestamp), line); err != nil {
+			log.Printf("Error writing Kafka message at offset %d: %s", msg.Offset, err)
+		}
+		session.MarkMessage(msg, "")
+	}
+	return nil
+}
+
+// runKafkaSource consumes cfg.Topic as a consumer group and writes each
+// message to its hourly file until ctx is cancelled, running every message
+// through filterChain and formatting it via outputTemplate/format exactly
+// like the ES-dump path does. The caller is responsible for closing
+// writers once this returns.
+func runKafkaSource(ctx context.Context, cfg *KafkaConfig, writers *hourlyWriterManager, filterChain []Filter, outputTemplate *template.Template, format string) error {
+	consumerConfig := sarama.NewConfig()
+	consumerConfig.Consumer.Offsets.Initial = sarama.OffsetOldest
+
+	group, err := sarama.NewConsumerGroup(cfg.Brokers, cfg.GroupID, consumerConfig)
+	if err != nil {
+		return fmt.Errorf("creating Kafka consumer group: %w", err)
+	}
+	defer group.Close()
+
+	handler := &kafkaConsumerHandler{
+		writers:        writers,
+		filterChain:    filterChain,
+		outputTemplate: outputTemplate,
+		format:         format,
+	}
+	for {
+		if err := group.Consume(ctx, []string{cfg.Topic}, handler); err != nil {
+			return fmt.Errorf("consuming topic %s: %w", cfg.Topic, err)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestFieldByPath(t *testing.T) {
+	source := map[string]interface{}{
+		"url": map[string]interface{}{
+			"domain": "example.com",
+		},
+		"message": "hello",
+	}
+
+	tests := []struct {
+		path      string
+		wantValue string
+		wantOK    bool
+	}{
+		{"url.domain", "example.com", true},
+		{"message", "hello", true},
+		{"url.missing", "", false},
+		{"missing", "", false},
+		{"url.domain.extra", "", false},
+		{"message.nested", "", false},
+	}
+
+	for _, tt := range tests {
+		value, ok := fieldByPath(source, tt.path)
+		if value != tt.wantValue || ok != tt.wantOK {
+			t.Errorf("fieldByPath(%q) = (%q, %v), want (%q, %v)", tt.path, value, ok, tt.wantValue, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// rotatingWriter is one open hourly bucket: a bufio.Writer over a
+// gzip.Writer over a .txt.gz file. It tracks how many bytes and lines it
+// has written so the manager can decide when to roll over to the next
+// sequence number.
+type rotatingWriter struct {
+	bucket string
+	seq    int
+	bytes  int64
+	lines  int
+
+	file *os.File
+	gz   *gzip.Writer
+	bw   *bufio.Writer
+}
+
+func (w *rotatingWriter) close() error {
+	if err := w.bw.Flush(); err != nil {
+		w.gz.Close()
+		w.file.Close()
+		return fmt.Errorf("flushing %s: %w", w.file.Name(), err)
+	}
+	if err := w.gz.Close(); err != nil {
+		w.file.Close()
+		return fmt.Errorf("closing gzip stream for %s: %w", w.file.Name(), err)
+	}
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("closing %s: %w", w.file.Name(), err)
+	}
+	return nil
+}
+
+// hourlyWriterManager keeps one rotatingWriter open per hourly bucket,
+// guarded by a mutex, instead of opening and closing a file on every
+// write -- opening a file per hit is the dominant syscall cost at scale.
+// Buckets roll over to a new sequence number before a line would take
+// the current segment past maxFileSize bytes, or once it holds
+// maxFileLines lines (either limit of 0 disables that check). A single
+// line longer than maxFileSize still goes into an empty segment.
+type hourlyWriterManager struct {
+	mu sync.Mutex
+
+	logStoreDir  string
+	maxFileSize  int64
+	maxFileLines int
+
+	writers map[string]*rotatingWriter
+}
+
+func newHourlyWriterManager(logStoreDir string, maxFileSize int64, maxFileLines int) *hourlyWriterManager {
+	return &hourlyWriterManager{
+		logStoreDir:  logStoreDir,
+		maxFileSize:  maxFileSize,
+		maxFileLines: maxFileLines,
+		writers:      map[string]*rotatingWriter{},
+	}
+}
+
+func (m *hourlyWriterManager) openWriter(bucket string, seq int) (*rotatingWriter, error) {
+	fileName := fmt.Sprintf("%s.%03d.txt.gz", bucket, seq)
+	filePath := filepath.Join(m.logStoreDir, fileName)
+
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", filePath, err)
+	}
+
+	gz := gzip.NewWriter(file)
+	return &rotatingWriter{
+		bucket: bucket,
+		seq:    seq,
+		file:   file,
+		gz:     gz,
+		bw:     bufio.NewWriter(gz),
+	}, nil
+}
+
+// WriteLine appends line (without a trailing newline) to bucket's
+// currently open rotatingWriter, rolling over to a new file first if the
+// line would push the current one past its size limit or it already
+// holds its line limit.
+func (m *hourlyWriterManager) WriteLine(bucket, line string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w, ok := m.writers[bucket]
+	if !ok {
+		var err error
+		w, err = m.openWriter(bucket, 0)
+		if err != nil {
+			return err
+		}
+		m.writers[bucket] = w
+	}
+
+	overSize := m.maxFileSize > 0 && w.bytes+int64(len(line)+1) > m.maxFileSize
+	overLines := m.maxFileLines > 0 && w.lines >= m.maxFileLines
+	if (overSize || overLines) && w.lines > 0 {
+		if err := w.close(); err != nil {
+			return err
+		}
+		var err error
+		w, err = m.openWriter(bucket, w.seq+1)
+		if err != nil {
+			return err
+		}
+		m.writers[bucket] = w
+	}
+
+	n, err := w.bw.WriteString(line + "\n")
+	w.bytes += int64(n)
+	if err != nil {
+		return fmt.Errorf("writing to %s: %w", w.file.Name(), err)
+	}
+	w.lines++
+	return nil
+}
+
+// CloseAll flushes and closes every open writer. It is safe to call once
+// on shutdown, whether triggered by scroll exhaustion or SIGINT.
+func (m *hourlyWriterManager) CloseAll() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var firstErr error
+	for bucket, w := range m.writers {
+		if err := w.close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("closing bucket %s: %w", bucket, err)
+		}
+	}
+	m.writers = map[string]*rotatingWriter{}
+	return firstErr
+}
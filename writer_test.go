@@ -0,0 +1,96 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readGzipFile(t *testing.T, path string) string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("opening %s: %s", path, err)
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("opening gzip reader for %s: %s", path, err)
+	}
+	defer gz.Close()
+
+	data, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("reading %s: %s", path, err)
+	}
+	return string(data)
+}
+
+func TestHourlyWriterManagerRollsOverOnLineLimit(t *testing.T) {
+	dir := t.TempDir()
+	m := newHourlyWriterManager(dir, 0, 2)
+
+	for _, line := range []string{"one", "two", "three"} {
+		if err := m.WriteLine("2024-01-01-00", line); err != nil {
+			t.Fatalf("WriteLine: %s", err)
+		}
+	}
+	if err := m.CloseAll(); err != nil {
+		t.Fatalf("CloseAll: %s", err)
+	}
+
+	first := readGzipFile(t, filepath.Join(dir, "2024-01-01-00.000.txt.gz"))
+	if first != "one\ntwo\n" {
+		t.Errorf("segment 000 = %q, want %q", first, "one\ntwo\n")
+	}
+	second := readGzipFile(t, filepath.Join(dir, "2024-01-01-00.001.txt.gz"))
+	if second != "three\n" {
+		t.Errorf("segment 001 = %q, want %q", second, "three\n")
+	}
+}
+
+func TestHourlyWriterManagerRollsOverOnSizeLimit(t *testing.T) {
+	dir := t.TempDir()
+	// Each "xxxx" line is 5 bytes written (4 + newline); cap at 8 so the
+	// second line doesn't fit in the first segment.
+	m := newHourlyWriterManager(dir, 8, 0)
+
+	for _, line := range []string{"xxxx", "yyyy"} {
+		if err := m.WriteLine("2024-01-01-00", line); err != nil {
+			t.Fatalf("WriteLine: %s", err)
+		}
+	}
+	if err := m.CloseAll(); err != nil {
+		t.Fatalf("CloseAll: %s", err)
+	}
+
+	first := readGzipFile(t, filepath.Join(dir, "2024-01-01-00.000.txt.gz"))
+	if first != "xxxx\n" {
+		t.Errorf("segment 000 = %q, want %q", first, "xxxx\n")
+	}
+	second := readGzipFile(t, filepath.Join(dir, "2024-01-01-00.001.txt.gz"))
+	if second != "yyyy\n" {
+		t.Errorf("segment 001 = %q, want %q", second, "yyyy\n")
+	}
+}
+
+func TestHourlyWriterManagerNoLimitsSingleSegment(t *testing.T) {
+	dir := t.TempDir()
+	m := newHourlyWriterManager(dir, 0, 0)
+
+	for i := 0; i < 10; i++ {
+		if err := m.WriteLine("2024-01-01-00", "line"); err != nil {
+			t.Fatalf("WriteLine: %s", err)
+		}
+	}
+	if err := m.CloseAll(); err != nil {
+		t.Fatalf("CloseAll: %s", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "2024-01-01-00.001.txt.gz")); !os.IsNotExist(err) {
+		t.Fatalf("expected no second segment, stat err = %v", err)
+	}
+}